@@ -1,16 +1,44 @@
 package generator
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/format"
 	"go/token"
+	"go/types"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 
 	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
+)
+
+// Mode selects how Generator turns an included type into a String()
+// receiver.
+type Mode int
+
+const (
+	// ModeName generates the original typestringer receiver: FormatString
+	// is used as a printf template with the type's name as the first
+	// operand and its display name (the type's name unless a
+	// "typestringer:name" directive gives another) as the second. This
+	// is the default Mode.
+	ModeName Mode = iota
+	// ModeEnum discovers the package's constant declarations of the
+	// included type and emits a String() returning the matching
+	// constant's identifier, similarly to stringer: an index table for
+	// a contiguous run of integer values, a switch otherwise.
+	ModeEnum
 )
 
 // Generator contains the configuration for String() generation based on
@@ -18,14 +46,55 @@ import (
 type Generator struct {
 	// Patterns passed to packages.Load.
 	Patterns []string
+	// Directory in which to run packages.Load, as packages.Config.Dir.
+	// If left empty, the current working directory is used.
+	Dir string
+	// Environment variables, in "K=V" form, passed to packages.Load as
+	// packages.Config.Env. If left empty, the current process's
+	// environment is used.
+	Env []string
+	// Additional flags passed to the build system through
+	// packages.Config.BuildFlags, e.g. "-mod=mod". BuildTags are
+	// appended to this as a further "-tags" flag.
+	BuildFlags []string
+	// Build tags to compile with, equivalent to passing
+	// "-tags=a,b,c" in BuildFlags.
+	BuildTags []string
+	// Context governs the package load performed by Generate; a
+	// cancelled or timed-out Context aborts packages.Load. If left nil,
+	// context.Background() is used.
+	Context context.Context
 	// List of regular expressions to determine which types are included.
 	// Empty list means to include all types by default.
 	Includes []*regexp.Regexp
 	// List of regular expressions to determine which types are ignored.
 	// Ignores takes precedence over Inludes.
 	Ignores []*regexp.Regexp
-	// Format string for writing out the type's String() receiver.
-	Format string
+	// Format string for writing out the type's String() receiver. Only
+	// used when Mode is ModeName.
+	FormatString string
+	// Mode selects how included types are turned into String()
+	// receivers. Defaults to ModeName. Ignored once Template is set.
+	Mode Mode
+	// If set, Template is executed once per included type instead of
+	// using FormatString or the ModeEnum switch generator, with a
+	// *TemplateData describing the type as its data. This allows
+	// generating things FormatString cannot, such as struct field
+	// dumps or bitmask decoders. See TemplateName, TemplateEnum, and
+	// TemplateStruct for ready-made starting points.
+	Template *template.Template
+	// If set true, the generated output is run through go/format.Source
+	// before being written out. If OrganizeImports is also set, that
+	// takes precedence. On a formatting error, the raw, unformatted
+	// output is written instead and the error is reported through
+	// DiagnosticOutput.
+	Format bool
+	// If set true, the generated output is run through
+	// golang.org/x/tools/imports.Process, which both formats the source
+	// and adds or removes imports as needed. Implies Format. On error,
+	// the raw, unformatted output is written instead and the error is
+	// reported through DiagnosticOutput.
+	OrganizeImports bool
 	// Function used to create the output Writer for generated files. If
 	// left empty, the generated output is directed to a file in the target
 	// package with its filename determined by FormatFilename.
@@ -49,6 +118,20 @@ type Generator struct {
 	Preamble string
 	// If set true, generation will not output "package <name>".
 	NoPackage bool
+	// If set true, the receiver generated by ModeEnum and by Template
+	// (via TemplateData.Receiver) is "*T" instead of the default "T".
+	// This is required when a type's other methods already use pointer
+	// receivers, since Go does not allow mixing receiver kinds across a
+	// type's method set. FormatString is unaffected, since its receiver
+	// is already whatever literal text the caller wrote.
+	PointerReceiver bool
+	// If set true, a "var _ fmt.Stringer = (*T)(nil)" is emitted after
+	// every generated type, causing the build to fail immediately if a
+	// String() signature ever drifts. The pointer form is used
+	// regardless of PointerReceiver: a pointer's method set always
+	// includes its value receiver methods too, so it satisfies
+	// fmt.Stringer either way.
+	AssertStringer bool
 }
 type WriteCloserCreator func(filepath string, module string) (io.WriteCloser, error)
 
@@ -59,8 +142,21 @@ func (g *Generator) Generate() error {
 	if g.WriteCloserCreator == nil {
 		g.WriteCloserCreator = g.defaultwg
 	}
+	ctx := g.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	buildflags := g.BuildFlags
+	if len(g.BuildTags) > 0 {
+		buildflags = append(append([]string{}, buildflags...), "-tags="+strings.Join(g.BuildTags, ","))
+	}
 	cfg := &packages.Config{
-		Mode: packages.NeedFiles | packages.NeedSyntax,
+		Mode: packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo,
+		Context:    ctx,
+		Dir:        g.Dir,
+		Env:        g.Env,
+		BuildFlags: buildflags,
 	}
 	ps, err := packages.Load(cfg, g.Patterns...)
 	if err != nil {
@@ -100,11 +196,77 @@ func (g *Generator) defaultwg(path, mod string) (io.WriteCloser, error) {
 	return w, nil
 }
 
+// typecandidate is a type that survived Includes/Ignores filtering, along
+// with any per-type directives found in its doc comments.
+type typecandidate struct {
+	Name       string
+	Directives directives
+	Spec       *ast.TypeSpec
+	Decl       *ast.GenDecl
+}
+
+// directives holds the per-type overrides recognized from
+// "//typestringer:..." doc comments.
+type directives struct {
+	skip      bool
+	enum      bool
+	hasFormat bool
+	format    string
+	hasName   bool
+	name      string
+}
+
+// parsedirectives scans the given comment groups, in order, for
+// "typestringer:" directive lines and merges them into a single
+// directives value. Later groups take precedence, so passing a
+// *ast.GenDecl's Doc before a *ast.TypeSpec's Doc lets a directive on the
+// individual type override one on its enclosing declaration.
+func parsedirectives(groups ...*ast.CommentGroup) directives {
+	var d directives
+	for _, cg := range groups {
+		if cg == nil {
+			continue
+		}
+		// cg.Text() strips lines that look like compiler/tool
+		// directives (e.g. "//go:generate"), which is exactly the
+		// shape our own directives take, so the raw comments are
+		// inspected instead.
+		for _, c := range cg.List {
+			line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			if !strings.HasPrefix(line, "typestringer:") {
+				continue
+			}
+			fields := strings.SplitN(strings.TrimPrefix(line, "typestringer:"), " ", 2)
+			switch fields[0] {
+			case "skip":
+				d.skip = true
+			case "enum":
+				d.enum = true
+			case "format":
+				if len(fields) == 2 {
+					if s, err := strconv.Unquote(strings.TrimSpace(fields[1])); err == nil {
+						d.hasFormat = true
+						d.format = s
+					}
+				}
+			case "name":
+				if len(fields) == 2 {
+					if s, err := strconv.Unquote(strings.TrimSpace(fields[1])); err == nil {
+						d.hasName = true
+						d.name = s
+					}
+				}
+			}
+		}
+	}
+	return d
+}
+
 func (g *Generator) HandlePackage(p *packages.Package) error {
 	if len(p.GoFiles) == 0 {
 		return errors.New("no Go files in package")
 	}
-	typenames := []string{}
+	typecandidates := []typecandidate{}
 	var packagename string
 	for _, a := range p.Syntax {
 		packagename = a.Name.Name
@@ -139,8 +301,18 @@ func (g *Generator) HandlePackage(p *packages.Package) error {
 						continue
 					}
 				}
+				dirs := parsedirectives(gd.Doc, ts.Doc)
+				if dirs.skip {
+					fmt.Fprintln(g.DiagnosticOutput, "skipped by directive:", tn)
+					continue
+				}
 				fmt.Fprintln(g.DiagnosticOutput, "including:", tn)
-				typenames = append(typenames, ts.Name.Name)
+				typecandidates = append(typecandidates, typecandidate{
+					Name:       tn,
+					Directives: dirs,
+					Spec:       ts,
+					Decl:       gd,
+				})
 			}
 		}
 	}
@@ -157,17 +329,68 @@ func (g *Generator) HandlePackage(p *packages.Package) error {
 			panic(errors.New("nil WriteCloser"))
 		}
 	}
+	var buf bytes.Buffer
 	if len(g.Header) > 0 {
-		fmt.Fprint(w, g.Header)
+		fmt.Fprint(&buf, g.Header)
 	}
 	if !g.NoPackage {
-		fmt.Fprintf(w, "package %s\n\n", packagename)
+		fmt.Fprintf(&buf, "package %s\n\n", packagename)
 	}
 	if len(g.Preamble) > 0 {
-		fmt.Fprint(w, g.Preamble, "\n\n")
+		fmt.Fprint(&buf, g.Preamble, "\n\n")
+	}
+	var enums map[string][]enumconst
+	enumsfor := func(name string) []enumconst {
+		if enums == nil {
+			enums = collectEnums(p)
+		}
+		return enums[name]
+	}
+	for _, tc := range typecandidates {
+		if g.Template != nil {
+			data := g.templatedata(p, tc, enumsfor(tc.Name))
+			if err := g.Template.Execute(&buf, data); err != nil {
+				return fmt.Errorf("template execute for %s: %w", tc.Name, err)
+			}
+			g.writeAssertion(&buf, tc.Name)
+			continue
+		}
+		mode := g.Mode
+		if tc.Directives.enum {
+			mode = ModeEnum
+		}
+		if mode == ModeEnum {
+			g.writeEnumString(&buf, tc.Name, enumsfor(tc.Name))
+			g.writeAssertion(&buf, tc.Name)
+			continue
+		}
+		formatstring := g.FormatString
+		if tc.Directives.hasFormat {
+			formatstring = tc.Directives.format
+		}
+		displayname := tc.Name
+		if tc.Directives.hasName {
+			displayname = tc.Directives.name
+		}
+		fmt.Fprintf(&buf, formatstring, tc.Name, displayname)
+		g.writeAssertion(&buf, tc.Name)
+	}
+	out := buf.Bytes()
+	if g.OrganizeImports {
+		if formatted, err := imports.Process("", out, nil); err != nil {
+			fmt.Fprintln(g.DiagnosticOutput, "goimports error, writing raw source:", err)
+		} else {
+			out = formatted
+		}
+	} else if g.Format {
+		if formatted, err := format.Source(out); err != nil {
+			fmt.Fprintln(g.DiagnosticOutput, "gofmt error, writing raw source:", err)
+		} else {
+			out = formatted
+		}
 	}
-	for _, tn := range typenames {
-		fmt.Fprintf(w, g.Format, tn, tn)
+	if _, err := w.Write(out); err != nil {
+		return err
 	}
 	if !g.NoClose {
 		w.Close()
@@ -175,6 +398,169 @@ func (g *Generator) HandlePackage(p *packages.Package) error {
 	return nil
 }
 
+// enumconst is a single named constant value discovered for some named
+// type.
+type enumconst struct {
+	Name  string
+	Value constant.Value
+}
+
+// enumentry is a deduplicated enumconst: Aliases holds the names of any
+// further constants sharing Value.
+type enumentry struct {
+	Name    string
+	Value   constant.Value
+	Aliases []string
+}
+
+// collectEnums walks p's syntax trees for constant declarations and
+// groups their values by the name of their named type.
+func collectEnums(p *packages.Package) map[string][]enumconst {
+	out := map[string][]enumconst{}
+	for _, a := range p.Syntax {
+		for _, decl := range a.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, sp := range gd.Specs {
+				vs, ok := sp.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for _, name := range vs.Names {
+					obj, ok := p.TypesInfo.Defs[name]
+					if !ok || obj == nil {
+						continue
+					}
+					c, ok := obj.(*types.Const)
+					if !ok {
+						continue
+					}
+					named, ok := c.Type().(*types.Named)
+					if !ok {
+						continue
+					}
+					tn := named.Obj().Name()
+					out[tn] = append(out[tn], enumconst{Name: name.Name, Value: c.Val()})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// writeEnumString emits a String() receiver for typename from its
+// discovered constants, matching what stringer produces for the
+// equivalent type: a name string with an index table when the values
+// form a contiguous integer run, a switch otherwise. Constants sharing a
+// value are deduplicated, keeping the first declared name; the switch
+// form notes the other names in a comment, the index table form omits
+// them.
+func (g *Generator) writeEnumString(w io.Writer, typename string, consts []enumconst) {
+	sort.SliceStable(consts, func(i, j int) bool {
+		return constant.Compare(consts[i].Value, token.LSS, consts[j].Value)
+	})
+	var entries []enumentry
+	byvalue := map[string]int{}
+	for _, c := range consts {
+		key := c.Value.String()
+		if i, ok := byvalue[key]; ok {
+			entries[i].Aliases = append(entries[i].Aliases, c.Name)
+			continue
+		}
+		byvalue[key] = len(entries)
+		entries = append(entries, enumentry{Name: c.Name, Value: c.Value})
+	}
+	receiver := typename
+	value := "t"
+	if g.PointerReceiver {
+		receiver = "*" + typename
+		value = "*t"
+	}
+	if contiguous, min, max := enumcontiguous(entries); contiguous {
+		fmt.Fprintf(w, "const _%sName = \"", typename)
+		offsets := make([]int, 0, len(entries)+1)
+		offset := 0
+		for _, e := range entries {
+			offsets = append(offsets, offset)
+			fmt.Fprint(w, e.Name)
+			offset += len(e.Name)
+		}
+		offsets = append(offsets, offset)
+		fmt.Fprint(w, "\"\n")
+		fmt.Fprintf(w, "var _%sIndex = [...]int{", typename)
+		for i, o := range offsets {
+			if i > 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprint(w, o)
+		}
+		fmt.Fprint(w, "}\n\n")
+		fmt.Fprintf(w, "func (t %s) String() string {\n", receiver)
+		fmt.Fprintf(w, "\tif %s < %d || %s > %d {\n", value, min, value, max)
+		fmt.Fprintf(w, "\t\treturn fmt.Sprintf(\"%s(%%d)\", %s)\n", typename, value)
+		fmt.Fprint(w, "\t}\n")
+		fmt.Fprintf(w, "\treturn _%sName[_%sIndex[%s-(%d)]:_%sIndex[%s-(%d)+1]]\n", typename, typename, value, min, typename, value, min)
+		fmt.Fprint(w, "}\n\n")
+		return
+	}
+	fmt.Fprintf(w, "func (t %s) String() string {\n", receiver)
+	fmt.Fprintf(w, "\tswitch %s {\n", value)
+	for _, e := range entries {
+		if len(e.Aliases) > 0 {
+			fmt.Fprintf(w, "\t// %s also has the same value as: %s\n", e.Name, joincomma(e.Aliases))
+		}
+		fmt.Fprintf(w, "\tcase %s:\n\t\treturn %q\n", e.Name, e.Name)
+	}
+	fmt.Fprint(w, "\tdefault:\n")
+	fmt.Fprintf(w, "\t\treturn fmt.Sprintf(\"%s(%%d)\", %s)\n", typename, value)
+	fmt.Fprint(w, "\t}\n}\n\n")
+}
+
+// writeAssertion emits a "var _ fmt.Stringer = (*T)(nil)" compile-time
+// check for typename when Generator.AssertStringer is set.
+func (g *Generator) writeAssertion(w io.Writer, typename string) {
+	if !g.AssertStringer {
+		return
+	}
+	fmt.Fprintf(w, "var _ fmt.Stringer = (*%s)(nil)\n\n", typename)
+}
+
+// enumcontiguous reports whether entries are integer-valued and form an
+// unbroken run, in which case min and max are the smallest and largest
+// values seen.
+func enumcontiguous(entries []enumentry) (bool, int64, int64) {
+	if len(entries) == 0 {
+		return false, 0, 0
+	}
+	vals := make([]int64, len(entries))
+	for i, e := range entries {
+		if e.Value.Kind() != constant.Int {
+			return false, 0, 0
+		}
+		v, ok := constant.Int64Val(e.Value)
+		if !ok {
+			return false, 0, 0
+		}
+		vals[i] = v
+	}
+	for i := 1; i < len(vals); i++ {
+		if vals[i] != vals[i-1]+1 {
+			return false, 0, 0
+		}
+	}
+	return true, vals[0], vals[len(vals)-1]
+}
+
+func joincomma(ss []string) string {
+	out := ss[0]
+	for _, s := range ss[1:] {
+		out += ", " + s
+	}
+	return out
+}
+
 var (
 	// Format string for writing out the type-specific receiver. May of
 	// course be set to something completely different. The formatted
@@ -183,3 +569,128 @@ var (
 	// Format string for determining the generated filenames.
 	FormatFilename = "%s_strings.go"
 )
+
+// TemplateField describes a single field of a struct type, for use in
+// TemplateData.Fields.
+type TemplateField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// TemplateEnumValue describes a single constant discovered for a type, for
+// use in TemplateData.EnumValues.
+type TemplateEnumValue struct {
+	Name  string
+	Value string
+}
+
+// TemplateData is the value Generator.Template is executed with, once per
+// included type.
+type TemplateData struct {
+	Name    string
+	Package string
+	// Receiver is "Name" or, when Generator.PointerReceiver is set,
+	// "*Name". Built-in templates declare their receiver as
+	// "func (t {{.Receiver}})" so they honor PointerReceiver
+	// automatically.
+	Receiver string
+	// PointerReceiver mirrors Generator.PointerReceiver, for templates
+	// that need to branch on it directly rather than only using
+	// Receiver.
+	PointerReceiver bool
+	// Kind is "struct" for struct types, the name of the underlying
+	// basic type for basic types, e.g. "int" or "string", and otherwise
+	// the underlying type's string form, e.g. "[]int".
+	Kind string
+	// Fields is populated when Kind is "struct".
+	Fields []TemplateField
+	// EnumValues is populated with any constants of this type found in
+	// the package, regardless of Mode.
+	EnumValues []TemplateEnumValue
+	// Doc is the type's doc comment, if any, with typestringer
+	// directive lines and comment markers stripped.
+	Doc string
+	// Comment is the type's trailing line comment, if any.
+	Comment string
+}
+
+// templatedata builds the TemplateData for tc.
+func (g *Generator) templatedata(p *packages.Package, tc typecandidate, enums []enumconst) TemplateData {
+	receiver := tc.Name
+	if g.PointerReceiver {
+		receiver = "*" + tc.Name
+	}
+	data := TemplateData{
+		Name:            tc.Name,
+		Package:         p.Types.Name(),
+		Receiver:        receiver,
+		PointerReceiver: g.PointerReceiver,
+	}
+	if tc.Spec.Doc != nil {
+		data.Doc = strings.TrimSpace(tc.Spec.Doc.Text())
+	} else if tc.Decl.Doc != nil {
+		data.Doc = strings.TrimSpace(tc.Decl.Doc.Text())
+	}
+	if tc.Spec.Comment != nil {
+		data.Comment = strings.TrimSpace(tc.Spec.Comment.Text())
+	}
+	if obj, ok := p.TypesInfo.Defs[tc.Spec.Name]; ok && obj != nil {
+		switch u := obj.Type().Underlying().(type) {
+		case *types.Struct:
+			data.Kind = "struct"
+			for i := 0; i < u.NumFields(); i++ {
+				f := u.Field(i)
+				data.Fields = append(data.Fields, TemplateField{
+					Name: f.Name(),
+					Type: f.Type().String(),
+					Tag:  u.Tag(i),
+				})
+			}
+		case *types.Basic:
+			data.Kind = u.Name()
+		default:
+			data.Kind = u.String()
+		}
+	}
+	for _, c := range enums {
+		data.EnumValues = append(data.EnumValues, TemplateEnumValue{
+			Name:  c.Name,
+			Value: c.Value.ExactString(),
+		})
+	}
+	return data
+}
+
+var (
+	// TemplateName is a ready-made Template equivalent to the default
+	// ModeName generation: a String() returning the type's own name.
+	TemplateName = template.Must(template.New("typestringer-name").Parse(
+		`func (t {{.Receiver}}) String() string { return "{{.Name}}" }
+`))
+	// TemplateEnum is a ready-made Template similar to the ModeEnum
+	// switch generator, usable as a starting point for customization. It
+	// emits one case per entry of EnumValues; constants sharing a value
+	// are not deduplicated.
+	TemplateEnum = template.Must(template.New("typestringer-enum").Parse(
+		`func (t {{.Receiver}}) String() string {
+	switch {{if .PointerReceiver}}*t{{else}}t{{end}} {
+	{{- range .EnumValues}}
+	case {{.Name}}:
+		return "{{.Name}}"
+	{{- end}}
+	default:
+		return fmt.Sprintf("{{.Name}}(%d)", {{if .PointerReceiver}}*t{{else}}t{{end}})
+	}
+}
+
+`))
+	// TemplateStruct is a ready-made Template that dumps every field of
+	// a struct type, e.g. "Point{ X=1, Y=2 }".
+	TemplateStruct = template.Must(template.New("typestringer-struct").Parse(
+		`func (t {{.Receiver}}) String() string {
+	return fmt.Sprintf("{{.Name}}{{"{"}} {{range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f.Name}}=%v{{end}} }"{{range .Fields}}, t.{{.Name}}{{end}})
+}
+
+`))
+)
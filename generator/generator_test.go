@@ -5,6 +5,7 @@ import (
 	"io"
 	"regexp"
 	"testing"
+	"text/template"
 
 	"github.com/susji/typestringer/generator"
 	"golang.org/x/exp/slices"
@@ -31,11 +32,11 @@ func (cb *MultiBuffer) String() string {
 
 func TestOne(t *testing.T) {
 	base := &generator.Generator{
-		Patterns: []string{"./testdata/one"},
-		Includes: nil,
-		Ignores:  nil,
-		Format:   "%s,%s\n",
-		Header:   "// the header\n",
+		Patterns:     []string{"./testdata/one"},
+		Includes:     nil,
+		Ignores:      nil,
+		FormatString: "%s,%s\n",
+		Header:       "// the header\n",
 		Preamble: `import (
     "fmt"
     "os"
@@ -131,10 +132,10 @@ Int,Int
 
 func TestTwo(t *testing.T) {
 	base := &generator.Generator{
-		Patterns: []string{"./testdata/two/two1.go", "./testdata/two/two2.go"},
-		Includes: nil,
-		Ignores:  nil,
-		Format:   "%s,%s\n",
+		Patterns:     []string{"./testdata/two/two1.go", "./testdata/two/two2.go"},
+		Includes:     nil,
+		Ignores:      nil,
+		FormatString: "%s,%s\n",
 	}
 	t.Run("only one file", func(t *testing.T) {
 		cb := &MultiBuffer{}
@@ -181,12 +182,283 @@ SECOND,SECOND
 	})
 }
 
+func TestEnum(t *testing.T) {
+	base := &generator.Generator{
+		Patterns: []string{"./testdata/enum"},
+		Mode:     generator.ModeEnum,
+	}
+	t.Run("contiguous iota", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package enum
+
+const _ColorName = "RedGreenBlue"
+var _ColorIndex = [...]int{0, 3, 8, 12}
+
+func (t Color) String() string {
+	if t < 0 || t > 2 {
+		return fmt.Sprintf("Color(%d)", t)
+	}
+	return _ColorName[_ColorIndex[t-(0)]:_ColorIndex[t-(0)+1]]
+}
+
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+	t.Run("pointer receiver with assertion", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.PointerReceiver = true
+		g.AssertStringer = true
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package enum
+
+const _ColorName = "RedGreenBlue"
+var _ColorIndex = [...]int{0, 3, 8, 12}
+
+func (t *Color) String() string {
+	if *t < 0 || *t > 2 {
+		return fmt.Sprintf("Color(%d)", *t)
+	}
+	return _ColorName[_ColorIndex[*t-(0)]:_ColorIndex[*t-(0)+1]]
+}
+
+var _ fmt.Stringer = (*Color)(nil)
+
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+}
+
+func TestEnumNegative(t *testing.T) {
+	cb := &MultiBuffer{}
+	g := &generator.Generator{
+		Patterns: []string{"./testdata/enumneg"},
+		Mode:     generator.ModeEnum,
+	}
+	g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+		return cb, nil
+	}
+	if err := g.Generate(); err != nil {
+		t.Error(err)
+	}
+	want := []string{
+		`package enumneg
+
+const _LevelName = "LowMidHigh"
+var _LevelIndex = [...]int{0, 3, 6, 10}
+
+func (t Level) String() string {
+	if t < -1 || t > 1 {
+		return fmt.Sprintf("Level(%d)", t)
+	}
+	return _LevelName[_LevelIndex[t-(-1)]:_LevelIndex[t-(-1)+1]]
+}
+
+`}
+	if !slices.Equal(want, cb.history) {
+		t.Error(cb.history)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	base := &generator.Generator{
+		Patterns:     []string{"./testdata/format"},
+		FormatString: "func (t %s) String()string{return fmt.Sprintf(\"%s\")}\n",
+	}
+	t.Run("gofmt", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.Format = true
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package format
+
+func (t Widget) String() string { return fmt.Sprintf("Widget") }
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+	t.Run("goimports adds missing import", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.OrganizeImports = true
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package format
+
+import "fmt"
+
+func (t Widget) String() string { return fmt.Sprintf("Widget") }
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+}
+
+func TestDirectives(t *testing.T) {
+	base := &generator.Generator{
+		Patterns:     []string{"./testdata/directives"},
+		FormatString: `func (t %s) String() string { return "%s" }` + "\n",
+	}
+	cb := &MultiBuffer{}
+	g := *base
+	g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+		return cb, nil
+	}
+	if err := g.Generate(); err != nil {
+		t.Error(err)
+	}
+	want := []string{
+		`package directives
+
+func (t Renamed) String() string { return "Custom" }
+func (t Overridden) String() string { return "literal-Overridden" }
+func (t Plain) String() string { return "Plain" }
+const _LevelName = "LevelLowLevelHigh"
+var _LevelIndex = [...]int{0, 8, 17}
+
+func (t Level) String() string {
+	if t < 0 || t > 1 {
+		return fmt.Sprintf("Level(%d)", t)
+	}
+	return _LevelName[_LevelIndex[t-(0)]:_LevelIndex[t-(0)+1]]
+}
+
+`}
+	if !slices.Equal(want, cb.history) {
+		t.Error(cb.history)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	base := &generator.Generator{
+		Patterns: []string{"./testdata/template"},
+	}
+	t.Run("struct field dump", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.Template = generator.TemplateStruct
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package template
+
+func (t Point) String() string {
+	return fmt.Sprintf("Point{ X=%v, Y=%v }", t.X, t.Y)
+}
+
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+	t.Run("custom template sees doc and kind", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.Template = template.Must(template.New("custom").Parse(
+			"// {{.Name}} ({{.Kind}}): {{.Doc}}\n"))
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package template
+
+// Point (struct): Point documents a 2D point.
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+}
+
+func TestBuildTags(t *testing.T) {
+	base := &generator.Generator{
+		Patterns:     []string{"./testdata/buildtag"},
+		FormatString: "%s,%s\n",
+	}
+	t.Run("without the tag", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package buildtag
+
+Base,Base
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+	t.Run("with the tag", func(t *testing.T) {
+		cb := &MultiBuffer{}
+		g := *base
+		g.BuildTags = []string{"special"}
+		g.WriteCloserCreator = func(path, mod string) (io.WriteCloser, error) {
+			return cb, nil
+		}
+		if err := g.Generate(); err != nil {
+			t.Error(err)
+		}
+		want := []string{
+			`package buildtag
+
+Base,Base
+Tagged,Tagged
+`}
+		if !slices.Equal(want, cb.history) {
+			t.Error(cb.history)
+		}
+	})
+}
+
 func TestThree(t *testing.T) {
 	base := &generator.Generator{
-		Patterns: []string{"./testdata/three/threeone", "./testdata/three/threetwo"},
-		Includes: nil,
-		Ignores:  nil,
-		Format:   "%s,%s\n",
+		Patterns:     []string{"./testdata/three/threeone", "./testdata/three/threetwo"},
+		Includes:     nil,
+		Ignores:      nil,
+		FormatString: "%s,%s\n",
 	}
 	t.Run("both subpackages", func(t *testing.T) {
 		cb := &MultiBuffer{}
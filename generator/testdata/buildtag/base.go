@@ -0,0 +1,3 @@
+package buildtag
+
+type Base int
@@ -0,0 +1,5 @@
+//go:build special
+
+package buildtag
+
+type Tagged int
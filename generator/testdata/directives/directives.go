@@ -0,0 +1,20 @@
+package directives
+
+//typestringer:skip
+type Hidden int
+
+//typestringer:name "Custom"
+type Renamed int
+
+//typestringer:format "func (t %s) String() string { return \"literal-%s\" }\n"
+type Overridden int
+
+type Plain int
+
+//typestringer:enum
+type Level int
+
+const (
+	LevelLow Level = iota
+	LevelHigh
+)
@@ -0,0 +1,9 @@
+package enum
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
@@ -0,0 +1,9 @@
+package enumneg
+
+type Level int
+
+const (
+	Low Level = iota - 1
+	Mid
+	High
+)
@@ -0,0 +1,3 @@
+package format
+
+type Widget int
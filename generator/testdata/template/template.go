@@ -0,0 +1,7 @@
+package template
+
+// Point documents a 2D point.
+type Point struct {
+	X int
+	Y int `json:"y"`
+}